@@ -0,0 +1,131 @@
+package shclisem
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+//HostStats reports current usage for a single host under a host-partitioned RequestHandler.
+//Errors counts both transport-level failures and successful 429 / 5xx responses, since both
+//signal an unhealthy host for the adaptive-throttling use case this is meant to support.
+type HostStats struct {
+	Current int
+	Waiting int
+	Total   int
+	Errors  int
+}
+
+type hostSlot struct {
+	sem     *semaphore.Weighted
+	weight  int
+	current *counter
+	waiting *counter
+	total   *counter
+	errs    *counter
+}
+
+func newHostSlot(weight int) *hostSlot {
+	return &hostSlot{
+		sem:     semaphore.NewWeighted(int64(weight)),
+		weight:  weight,
+		current: newCounter("Running", 0, false),
+		waiting: newCounter("Waiting", 0, false),
+		total:   newCounter("Total", 0, true),
+		errs:    newCounter("Errors", 0, true),
+	}
+}
+
+/*
+NewHostPartitionedHandler builds a RequestHandler that maintains an independent weighted
+semaphore per destination host (derived from req.URL.Host), in addition to the usual global
+cap, so one slow backend can't starve requests to healthy ones. It composes with everything
+else on RequestHandler: SetRetryPolicy, SetRateLimit, Use, and SetTotalWeight (which adjusts
+the global cap) all keep working. In DoWeightedContext, the per-host semaphore is acquired
+first, then the global one, releasing in reverse order.
+*/
+func NewHostPartitionedHandler(globalWeight int, perHostWeight int, timeout time.Duration, cli *http.Client) *RequestHandler {
+	rh := NewRequestHandler(globalWeight, timeout, cli)
+	if perHostWeight < 1 {
+		perHostWeight = 1
+	}
+	rh.hostWeight = perHostWeight
+	rh.hosts = make(map[string]*hostSlot)
+	return rh
+}
+
+func (rh *RequestHandler) hostPartitioned() bool {
+	rh.hostsmux.RLock()
+	defer rh.hostsmux.RUnlock()
+	return rh.hosts != nil
+}
+
+//Overrides the weight used for host's semaphore. Resets any in-flight accounting for that
+//host, so it's meant for startup/config changes rather than toggling under live traffic.
+//Only meaningful on a RequestHandler built with NewHostPartitionedHandler.
+func (rh *RequestHandler) SetHostWeight(host string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	rh.hostsmux.Lock()
+	defer rh.hostsmux.Unlock()
+	if rh.hosts == nil {
+		return
+	}
+	rh.hosts[host] = newHostSlot(weight)
+}
+
+func (rh *RequestHandler) hostSlotFor(host string) *hostSlot {
+	rh.hostsmux.RLock()
+	slot, ok := rh.hosts[host]
+	rh.hostsmux.RUnlock()
+	if ok {
+		return slot
+	}
+	rh.hostsmux.Lock()
+	defer rh.hostsmux.Unlock()
+	if slot, ok := rh.hosts[host]; ok {
+		return slot
+	}
+	slot = newHostSlot(rh.hostWeight)
+	rh.hosts[host] = slot
+	return slot
+}
+
+//acquireHostSlot acquires the weighted semaphore for host, tracking the wait on the slot's
+//own waiting counter. The caller is responsible for releasing slot.sem.
+func (rh *RequestHandler) acquireHostSlot(ctx context.Context, host string, weight int) (*hostSlot, error) {
+	slot := rh.hostSlotFor(host)
+	if err := slot.waiting.Add(); err != nil {
+		return nil, err
+	}
+	if err := slot.sem.Acquire(ctx, int64(weight)); err != nil {
+		if errr := slot.waiting.Remove(); errr != nil {
+			return nil, errors.New(errr.Error() + " & " + err.Error())
+		}
+		return nil, err
+	}
+	if err := slot.waiting.Remove(); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+//Returns current usage stats for every host seen so far
+func (rh *RequestHandler) GetHostStats() map[string]HostStats {
+	rh.hostsmux.RLock()
+	defer rh.hostsmux.RUnlock()
+	stats := make(map[string]HostStats, len(rh.hosts))
+	for host, slot := range rh.hosts {
+		stats[host] = HostStats{
+			Current: slot.current.Count(),
+			Waiting: slot.waiting.Count(),
+			Total:   slot.total.Count(),
+			Errors:  slot.errs.Count(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package shclisem
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//A slow host must not starve a healthy one, even though both share the global cap.
+func TestHostPartitionedHandlerIsolatesSlowHost(t *testing.T) {
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer fast.Close()
+
+	rh := NewHostPartitionedHandler(4, 1, 5*time.Second, nil)
+
+	slowReq, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	done := make(chan struct{})
+	go func() {
+		resp, err := rh.DoWeightedContext(slowReq, 1, context.Background())
+		if err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the first call occupy the slow host's slot
+
+	slowWaiterCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	slowWaiterReq, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	if _, err := rh.DoWeightedContext(slowWaiterReq, 1, slowWaiterCtx); err == nil {
+		t.Fatal("expected a second call to the busy host to be starved of its per-host weight of 1")
+	}
+
+	fastCtx, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	fastReq, _ := http.NewRequest(http.MethodGet, fast.URL, nil)
+	resp, err := rh.DoWeightedContext(fastReq, 1, fastCtx)
+	if err != nil {
+		t.Fatalf("expected the healthy host to be unaffected by the busy one, got %v", err)
+	}
+	resp.Body.Close()
+
+	close(block)
+	<-done
+}
+
+//Two different hosts still can't both run concurrently when the global cap is exhausted,
+//even with a generous per-host weight.
+func TestHostPartitionedHandlerEnforcesGlobalCap(t *testing.T) {
+	block := make(chan struct{})
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srvA.Close()
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srvB.Close()
+
+	rh := NewHostPartitionedHandler(1, 4, 5*time.Second, nil)
+
+	reqA, _ := http.NewRequest(http.MethodGet, srvA.URL, nil)
+	done := make(chan struct{})
+	go func() {
+		resp, err := rh.DoWeightedContext(reqA, 1, context.Background())
+		if err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the first call occupy the sole global slot
+
+	reqB, _ := http.NewRequest(http.MethodGet, srvB.URL, nil)
+	ctxB, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rh.DoWeightedContext(reqB, 1, ctxB); err == nil {
+		t.Fatal("expected a different host to still be blocked by the exhausted global cap")
+	}
+
+	close(block)
+	<-done
+}
+
+func TestGetHostStatsCountsStatusErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	rh := NewHostPartitionedHandler(4, 4, 5*time.Second, nil)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := rh.DoWeightedContext(req, 1, context.Background())
+	if err != nil {
+		t.Fatalf("DoWeightedContext: %v", err)
+	}
+	resp.Body.Close()
+
+	stats := rh.GetHostStats()
+	got, ok := stats[req.URL.Host]
+	if !ok {
+		t.Fatalf("expected stats for host %q, got %v", req.URL.Host, stats)
+	}
+	if got.Total != 1 {
+		t.Fatalf("expected Total 1, got %d", got.Total)
+	}
+	if got.Errors != 1 {
+		t.Fatalf("expected Errors 1 for a 503 response, got %d", got.Errors)
+	}
+}
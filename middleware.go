@@ -0,0 +1,75 @@
+package shclisem
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+//RoundTripFunc performs (or observes) a single weighted call, the same shape DoWeightedContext
+//itself exposes once the semaphore acquire/release, counters, and client.Do are peeled off into
+//the terminal handler at the bottom of the chain.
+type RoundTripFunc func(ctx context.Context, req *http.Request, weight int) (*http.Response, error)
+
+//Middleware wraps a RoundTripFunc with another, letting callers observe or mutate requests,
+//responses, and errors without forking the package. See Use.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+type ctxKey int
+
+const queueTimingKey ctxKey = 0
+
+//queueTiming is stashed on the context passed into the chain so middleware can recover how
+//long a call spent queued for the semaphore versus actually running.
+type queueTiming struct {
+	entered  time.Time
+	acquired time.Time
+}
+
+//QueueWait returns how long the call spent waiting to acquire the semaphore (and rate limiter,
+//if any) before terminalRoundTrip began running it. Call from within a Middleware after next
+//has returned; returns 0 if ctx wasn't built by DoWeightedContext or the call never acquired.
+func QueueWait(ctx context.Context) time.Duration {
+	qt, ok := ctx.Value(queueTimingKey).(*queueTiming)
+	if !ok || qt.acquired.IsZero() {
+		return 0
+	}
+	return qt.acquired.Sub(qt.entered)
+}
+
+//RunDuration returns how long the call spent past the semaphore, i.e. in terminalRoundTrip's
+//client.Do loop. Call from within a Middleware after next has returned.
+func RunDuration(ctx context.Context) time.Duration {
+	qt, ok := ctx.Value(queueTimingKey).(*queueTiming)
+	if !ok || qt.acquired.IsZero() {
+		return 0
+	}
+	return time.Since(qt.acquired)
+}
+
+//Appends mw to the middleware chain. Middlewares run in the order added, wrapping outward
+//from terminalRoundTrip, so the first one added is the outermost and sees requests first.
+func (rh *RequestHandler) Use(mw ...Middleware) {
+	rh.mwmux.Lock()
+	defer rh.mwmux.Unlock()
+	rh.middlewares = append(rh.middlewares, mw...)
+}
+
+//Creates a new RequestHandler with the given total weight, per item wait timeout, and http
+//client (or the default if nil), then registers mw via Use
+func NewRequestHandlerWithMiddleware(tweight int, timeout time.Duration, cli *http.Client, mw ...Middleware) *RequestHandler {
+	rh := NewRequestHandler(tweight, timeout, cli)
+	rh.Use(mw...)
+	return rh
+}
+
+//buildChain wraps terminalRoundTrip with every registered middleware, outermost first.
+func (rh *RequestHandler) buildChain() RoundTripFunc {
+	rh.mwmux.RLock()
+	defer rh.mwmux.RUnlock()
+	rt := RoundTripFunc(rh.terminalRoundTrip)
+	for i := len(rh.middlewares) - 1; i >= 0; i-- {
+		rt = rh.middlewares[i](rt)
+	}
+	return rt
+}
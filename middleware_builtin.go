@@ -0,0 +1,27 @@
+package shclisem
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+//LoggingMiddleware logs method, URL, weight, queue wait, run duration, and error for every
+//call, as a reference implementation of the chain built by Use. Pass nil to use log.Default().
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *http.Request, weight int) (*http.Response, error) {
+			resp, err := next(ctx, req, weight)
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			logger.Printf("shclisem: %s %s weight=%d queue=%s run=%s status=%d err=%v",
+				req.Method, req.URL, weight, QueueWait(ctx), RunDuration(ctx), status, err)
+			return resp, err
+		}
+	}
+}
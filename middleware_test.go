@@ -0,0 +1,84 @@
+package shclisem
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//Middlewares run in the order added, wrapping outward from terminalRoundTrip: the first
+//one added sees the request first and the response last.
+func TestUseChainsMiddlewareInOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var order []string
+	record := func(name string) Middleware {
+		return func(next RoundTripFunc) RoundTripFunc {
+			return func(ctx context.Context, req *http.Request, weight int) (*http.Response, error) {
+				order = append(order, name+":before")
+				resp, err := next(ctx, req, weight)
+				order = append(order, name+":after")
+				return resp, err
+			}
+		}
+	}
+
+	rh := NewRequestHandlerWithMiddleware(1, 5*time.Second, nil, record("outer"), record("inner"))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := rh.DoWeightedContext(req, 1, context.Background())
+	if err != nil {
+		t.Fatalf("DoWeightedContext: %v", err)
+	}
+	resp.Body.Close()
+
+	want := []string{"outer:before", "inner:before", "inner:after", "outer:after"}
+	if len(order) != len(want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestQueueWaitAndRunDurationAreSane(t *testing.T) {
+	const runFor = 30 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(runFor)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var queueWait, runDuration time.Duration
+	observe := Middleware(func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *http.Request, weight int) (*http.Response, error) {
+			resp, err := next(ctx, req, weight)
+			queueWait = QueueWait(ctx)
+			runDuration = RunDuration(ctx)
+			return resp, err
+		}
+	})
+
+	rh := NewRequestHandlerWithMiddleware(1, 5*time.Second, nil, observe)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := rh.DoWeightedContext(req, 1, context.Background())
+	if err != nil {
+		t.Fatalf("DoWeightedContext: %v", err)
+	}
+	resp.Body.Close()
+
+	if queueWait < 0 {
+		t.Fatalf("expected non-negative queue wait, got %s", queueWait)
+	}
+	if runDuration < runFor {
+		t.Fatalf("expected run duration to cover the server's %s sleep, got %s", runFor, runDuration)
+	}
+}
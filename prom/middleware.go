@@ -0,0 +1,38 @@
+/*
+Package prom provides a Prometheus-backed shclisem.Middleware.
+
+It's split out from the core package so callers who never touch Prometheus don't pay for
+the dependency or the higher minimum Go version it requires.
+*/
+package prom
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/madman22/shclisem"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//Middleware records queue wait time, run duration, and total latency into the given
+//histograms, labelled by request method. Any of the three may be nil to skip that
+//observation.
+func Middleware(queueWait, running, latency *prometheus.HistogramVec) shclisem.Middleware {
+	return func(next shclisem.RoundTripFunc) shclisem.RoundTripFunc {
+		return func(ctx context.Context, req *http.Request, weight int) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next(ctx, req, weight)
+			if queueWait != nil {
+				queueWait.WithLabelValues(req.Method).Observe(shclisem.QueueWait(ctx).Seconds())
+			}
+			if running != nil {
+				running.WithLabelValues(req.Method).Observe(shclisem.RunDuration(ctx).Seconds())
+			}
+			if latency != nil {
+				latency.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
+			}
+			return resp, err
+		}
+	}
+}
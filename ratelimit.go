@@ -0,0 +1,62 @@
+package shclisem
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+//Option configures a RequestHandler at construction time. See NewRequestHandlerWithOptions.
+type Option func(*RequestHandler)
+
+//WithRateLimit caps throughput to rps requests per second with the given burst size, enforced
+//in DoWeightedContext alongside the weighted semaphore. It's useful when talking to APIs with
+//published QPS limits, where the semaphore alone only bounds concurrency, not rate. Each call
+//takes tokens equal to its weight, so rps and burst count weight units; a call whose weight
+//exceeds burst fails immediately.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(rh *RequestHandler) {
+		rh.SetRateLimit(rps, burst)
+	}
+}
+
+//Creates a new RequestHandler with the given total weight, per item wait timeout, and http
+//client (or the default if nil), then applies opts in order
+func NewRequestHandlerWithOptions(tweight int, timeout time.Duration, cli *http.Client, opts ...Option) *RequestHandler {
+	rh := NewRequestHandler(tweight, timeout, cli)
+	for _, opt := range opts {
+		opt(rh)
+	}
+	return rh
+}
+
+//Sets, replaces, or clears (rps <= 0) the requests-per-second cap enforced in DoWeightedContext
+func (rh *RequestHandler) SetRateLimit(rps float64, burst int) {
+	rh.limitmux.Lock()
+	defer rh.limitmux.Unlock()
+	if rps <= 0 {
+		rh.limiter = nil
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	rh.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+//Returns the current requests-per-second cap and burst size, or (0, 0) if none is set
+func (rh *RequestHandler) GetRateLimit() (rps float64, burst int) {
+	rh.limitmux.RLock()
+	defer rh.limitmux.RUnlock()
+	if rh.limiter == nil {
+		return 0, 0
+	}
+	return float64(rh.limiter.Limit()), rh.limiter.Burst()
+}
+
+func (rh *RequestHandler) getLimiter() *rate.Limiter {
+	rh.limitmux.RLock()
+	defer rh.limitmux.RUnlock()
+	return rh.limiter
+}
@@ -0,0 +1,47 @@
+package shclisem
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//WithRateLimit/SetRateLimit must actually throttle throughput, not just construct a limiter.
+func TestRateLimitThrottlesThroughput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rh := NewRequestHandlerWithOptions(10, 5*time.Second, nil, WithRateLimit(20, 1))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := rh.DoWeightedContext(req, 1, context.Background())
+		if err != nil {
+			t.Fatalf("DoWeightedContext: %v", err)
+		}
+		resp.Body.Close()
+	}
+	elapsed := time.Since(start)
+
+	// burst of 1 at 20rps means calls 2-5 each wait ~50ms for a fresh token.
+	if elapsed < 150*time.Millisecond {
+		t.Fatalf("expected 5 calls at 20rps/burst1 to take >=150ms, took %s", elapsed)
+	}
+}
+
+func TestSetRateLimitClearsLimiter(t *testing.T) {
+	rh := NewRequestHandler(1, time.Second, nil)
+	rh.SetRateLimit(5, 2)
+	if rps, burst := rh.GetRateLimit(); rps != 5 || burst != 2 {
+		t.Fatalf("expected rate limit 5/2, got %v/%v", rps, burst)
+	}
+	rh.SetRateLimit(0, 0)
+	if rps, burst := rh.GetRateLimit(); rps != 0 || burst != 0 {
+		t.Fatalf("expected rate limit cleared, got %v/%v", rps, burst)
+	}
+}
@@ -0,0 +1,153 @@
+package shclisem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+RetryPolicy controls whether and how DoWeightedContext retries a failed attempt.
+
+The semaphore weight acquired for the call is held across every attempt, so a
+retrying request doesn't let other waiters leapfrog it. Set MaxRetries to 0
+(the zero value) to disable retries.
+*/
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Jitter     bool
+	Retryable  func(*http.Response, error) bool
+}
+
+//DefaultRetryable is used when RetryPolicy.Retryable is nil. It retries on dial/connect and
+//DNS failures, a timed-out net.Error, and a context.DeadlineExceeded from the client call,
+//plus 5xx / 429 responses. It does not retry hard failures like an unsupported protocol
+//scheme or a TLS verification failure, since those can't succeed on a retry.
+func DefaultRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true
+		}
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		// *net.OpError (dial/connect failures such as refused or reset) and
+		// *net.DNSError are genuine network errors regardless of Timeout().
+		// http.Client always wraps transport errors in *url.Error, which itself
+		// satisfies net.Error by delegating Timeout()/Temporary() to its cause —
+		// so a bare net.Error check alone would also match hard failures like an
+		// unsupported protocol scheme. Check those two concrete types first, and
+		// only fall back to net.Error.Timeout() for anything else.
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			return true
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return true
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return netErr.Timeout()
+		}
+		return false
+	}
+	if resp == nil {
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func (p *RetryPolicy) retryable(resp *http.Response, err error) bool {
+	fn := p.Retryable
+	if fn == nil {
+		fn = DefaultRetryable
+	}
+	return fn(resp, err)
+}
+
+//nextDelay computes min(MaxDelay, BaseDelay*2^attempt), applying jitter in [0.5,1.5) to that
+//backoff component, then bumping up to the response's Retry-After when it asks for longer.
+//Retry-After is a floor the server explicitly asked for, so jitter must never scale it down.
+func (p *RetryPolicy) nextDelay(attempt int, resp *http.Response) time.Duration {
+	delay := p.BaseDelay * time.Duration(math.Pow(2, float64(attempt)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter {
+		delay = time.Duration(float64(delay) * (0.5 + rand.Float64()))
+	}
+	if resp != nil {
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil {
+				if d := time.Duration(secs) * time.Second; d > delay {
+					delay = d
+				}
+			}
+		}
+	}
+	return delay
+}
+
+//Replaces the retry policy used by DoWeightedContext. Pass a zero-value RetryPolicy
+//to disable retries.
+func (rh *RequestHandler) SetRetryPolicy(policy RetryPolicy) {
+	rh.retrymux.Lock()
+	defer rh.retrymux.Unlock()
+	rh.retryPolicy = &policy
+}
+
+func (rh *RequestHandler) getRetryPolicy() *RetryPolicy {
+	rh.retrymux.RLock()
+	defer rh.retrymux.RUnlock()
+	if rh.retryPolicy == nil || rh.retryPolicy.MaxRetries < 1 {
+		return nil
+	}
+	return rh.retryPolicy
+}
+
+//cloneRequestForAttempt rebuilds req for a retry attempt, rewinding the body via
+//req.GetBody. Requests without a body don't need GetBody set.
+func cloneRequestForAttempt(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("shclisem: request body cannot be rewound for retry, set req.GetBody or use DoWeightedContextRetry")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+//DoWeightedContextRetry behaves like DoWeightedContext but first wires req.GetBody (and
+//req.Body) up from the given bytes, for callers whose request wasn't built with GetBody
+//set and so can't otherwise be rewound between retry attempts.
+func (rh *RequestHandler) DoWeightedContextRetry(req *http.Request, weight int, ctx context.Context, body []byte) (*http.Response, error) {
+	if req == nil {
+		return nil, errors.New("Request is nil")
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = rc
+	req.ContentLength = int64(len(body))
+	return rh.DoWeightedContext(req, weight, ctx)
+}
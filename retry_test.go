@@ -0,0 +1,139 @@
+package shclisem
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoWeightedContextRetriesUntilSuccess(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rh := NewRequestHandlerWithRetry(4, 5*time.Second, nil, RetryPolicy{
+		MaxRetries: 5,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	})
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	resp, err := rh.DoWeightedContext(req, 1, context.Background())
+	if err != nil {
+		t.Fatalf("DoWeightedContext: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+	if got := rh.GetRetryCount(); got != 2 {
+		t.Fatalf("expected 2 retries recorded, got %d", got)
+	}
+}
+
+//A retrying call must keep holding its acquired weight across attempts so a fresh
+//caller can't leapfrog it while it's mid-backoff.
+func TestDoWeightedContextRetryHoldsWeightAcrossAttempts(t *testing.T) {
+	var attempts int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rh := NewRequestHandlerWithRetry(1, 5*time.Second, nil, RetryPolicy{
+		MaxRetries: 1,
+		BaseDelay:  time.Millisecond,
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	done := make(chan struct{})
+	go func() {
+		resp, err := rh.DoWeightedContext(req, 1, context.Background())
+		if err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the first attempt fail and the retry block on <-release
+
+	waiterReq, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	waiterCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rh.DoWeightedContext(waiterReq, 1, waiterCtx); err == nil {
+		t.Fatal("expected the waiter to be starved of the single weight unit still held by the in-flight retry")
+	}
+
+	close(release)
+	<-done
+}
+
+func TestDoWeightedContextRetryRequiresRewindableBody(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	rh := NewRequestHandlerWithRetry(1, 5*time.Second, nil, RetryPolicy{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("body"))
+	req.GetBody = nil // simulate a caller that didn't wire up a rewindable body
+
+	if _, err := rh.DoWeightedContext(req, 1, context.Background()); err == nil {
+		t.Fatal("expected an error when the request body can't be rewound for a retry")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("expected exactly 1 attempt before failing to rewind, got %d", got)
+	}
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{"server error", &http.Response{StatusCode: http.StatusServiceUnavailable}, nil, true},
+		{"too many requests", &http.Response{StatusCode: http.StatusTooManyRequests}, nil, true},
+		{"ok", &http.Response{StatusCode: http.StatusOK}, nil, false},
+		{"deadline exceeded", nil, context.DeadlineExceeded, true},
+		{"canceled", nil, context.Canceled, false},
+		{"unsupported protocol scheme", nil, &url.Error{Op: "Get", URL: "bogus://x", Err: errors.New(`unsupported protocol scheme "bogus"`)}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := DefaultRetryable(c.resp, c.err); got != c.want {
+				t.Fatalf("DefaultRetryable(%v, %v) = %v, want %v", c.resp, c.err, got, c.want)
+			}
+		})
+	}
+}
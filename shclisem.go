@@ -10,25 +10,34 @@ import (
 	"time"
 
 	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 )
 
-//TODO implement retry structure
-//		retry every x duration
-//		every error or only timeouts?
-
 /*
 Simple HTTP Client Semaphore
 
 For limiting concurrent client connections using weighting.  Use the NewRequestHandler function to build the struct.
 */
 type RequestHandler struct {
-	client  *http.Client
-	sem     *semaphore.Weighted
-	timeout time.Duration
-	current *counter
-	waiting *counter
-	total   *counter
-	errs    *counter
+	client      *http.Client
+	sem         *semaphore.Weighted
+	semmux      sync.RWMutex
+	tweight     int
+	timeout     time.Duration
+	current     *counter
+	waiting     *counter
+	total       *counter
+	errs        *counter
+	retries     *counter
+	retryPolicy *RetryPolicy
+	retrymux    sync.RWMutex
+	limiter     *rate.Limiter
+	limitmux    sync.RWMutex
+	middlewares []Middleware
+	mwmux       sync.RWMutex
+	hostWeight  int
+	hosts       map[string]*hostSlot
+	hostsmux    sync.RWMutex
 }
 
 type counter struct {
@@ -98,6 +107,7 @@ func NewRequestHandler(tweight int, timeout time.Duration, cli *http.Client) *Re
 		tweight = math.MaxInt32
 	}
 	rh.sem = semaphore.NewWeighted(int64(tweight))
+	rh.tweight = tweight
 	if timeout < 1*time.Second { //check to make sure the timeout isn't too short
 		timeout = 1 * time.Minute //set the default value for the per item timeout, 1 minute
 	} else if timeout > time.Hour {
@@ -113,9 +123,18 @@ func NewRequestHandler(tweight int, timeout time.Duration, cli *http.Client) *Re
 	rh.waiting = newCounter("Waiting", 0, false)
 	rh.total = newCounter("Total", 0, true)
 	rh.errs = newCounter("Errors", 0, true)
+	rh.retries = newCounter("Retries", 0, true)
 	return &rh
 }
 
+//Creates a new RequestHandler with the given total weight, per item wait timeout, http client (or the
+//default if nil), and retry policy applied to every call to DoWeightedContext
+func NewRequestHandlerWithRetry(tweight int, timeout time.Duration, cli *http.Client, policy RetryPolicy) *RequestHandler {
+	rh := NewRequestHandler(tweight, timeout, cli)
+	rh.SetRetryPolicy(policy)
+	return rh
+}
+
 //Tries to acquire semaphore using weight of 1 and default timeout, then runs the request on the http client
 func (rh *RequestHandler) Do(req *http.Request) (*http.Response, error) {
 	return rh.DoWeighted(req, 1)
@@ -145,29 +164,125 @@ func (rh *RequestHandler) DoWeightedContext(req *http.Request, weight int, ctx c
 	if err := rh.waiting.Add(); err != nil {
 		return nil, err
 	}
-	if err := rh.sem.Acquire(ctx, int64(weight)); err != nil {
-		if errr := rh.waiting.Remove(); err != nil {
+	qt := &queueTiming{entered: time.Now()}
+	if limiter := rh.getLimiter(); limiter != nil {
+		if err := limiter.WaitN(ctx, weight); err != nil {
+			if errr := rh.waiting.Remove(); errr != nil {
+				return nil, errors.New(errr.Error() + " & " + err.Error())
+			}
+			return nil, err
+		}
+	}
+	ctx = context.WithValue(ctx, queueTimingKey, qt)
+	return rh.buildChain()(ctx, req, weight)
+}
+
+//terminalRoundTrip acquires the semaphore, runs the (possibly retried) client.Do call,
+//and updates the counters. It's the handler at the bottom of the middleware chain built
+//by buildChain; everything above it in the chain only ever sees req/weight/ctx and the
+//resulting response/error.
+func (rh *RequestHandler) terminalRoundTrip(ctx context.Context, req *http.Request, weight int) (*http.Response, error) {
+	var slot *hostSlot
+	if rh.hostPartitioned() {
+		if req.URL == nil {
+			if errr := rh.waiting.Remove(); errr != nil {
+				return nil, errors.New(errr.Error() + " & Request URL is nil")
+			}
+			return nil, errors.New("Request URL is nil")
+		}
+		var err error
+		slot, err = rh.acquireHostSlot(ctx, req.URL.Host, weight)
+		if err != nil {
+			if errr := rh.waiting.Remove(); errr != nil {
+				return nil, errors.New(errr.Error() + " & " + err.Error())
+			}
+			return nil, err
+		}
+		defer slot.sem.Release(int64(weight))
+	}
+
+	sem := rh.getSem()
+	if err := sem.Acquire(ctx, int64(weight)); err != nil {
+		if errr := rh.waiting.Remove(); errr != nil {
 			return nil, errors.New(errr.Error() + " & " + err.Error())
 		}
 		return nil, err
 	}
-	defer rh.sem.Release(int64(weight))
+	if qt, ok := ctx.Value(queueTimingKey).(*queueTiming); ok {
+		qt.acquired = time.Now()
+	}
+	defer sem.Release(int64(weight))
 	if err := rh.waiting.Remove(); err != nil {
 		return nil, err
 	}
 	if err := rh.current.Add(); err != nil {
 		return nil, err
 	}
-	resp, err := rh.client.Do(req)
-	if errr := rh.current.Remove(); errr != nil {
-		rh.errs.Add()
-		return nil, errors.New(errr.Error() + " & " + err.Error())
+	if slot != nil {
+		if err := slot.current.Add(); err != nil {
+			return nil, err
+		}
 	}
-	if err != nil {
-		return nil, err
+
+	finish := func(resp *http.Response, err error) (*http.Response, error) {
+		if errr := rh.current.Remove(); errr != nil {
+			if err != nil {
+				return nil, errors.New(errr.Error() + " & " + err.Error())
+			}
+			return nil, errr
+		}
+		if slot != nil {
+			slot.current.Remove()
+		}
+		return resp, err
+	}
+
+	policy := rh.getRetryPolicy()
+	attempt := 0
+	for {
+		resp, err := rh.client.Do(req)
+		if policy == nil || attempt >= policy.MaxRetries || !policy.retryable(resp, err) {
+			if err != nil {
+				rh.errs.Add()
+				if slot != nil {
+					slot.errs.Add()
+				}
+				return finish(nil, err)
+			}
+			rh.total.Add()
+			if slot != nil {
+				slot.total.Add()
+				if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+					slot.errs.Add()
+				}
+			}
+			return finish(resp, nil)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		delay := policy.nextDelay(attempt, resp)
+		select {
+		case <-ctx.Done():
+			rh.errs.Add()
+			if slot != nil {
+				slot.errs.Add()
+			}
+			return finish(nil, ctx.Err())
+		case <-time.After(delay):
+		}
+		nextReq, rerr := cloneRequestForAttempt(req)
+		if rerr != nil {
+			rh.errs.Add()
+			if slot != nil {
+				slot.errs.Add()
+			}
+			return finish(nil, rerr)
+		}
+		req = nextReq
+		attempt++
+		rh.retries.Add()
 	}
-	rh.total.Add()
-	return resp, nil
 }
 
 //Returns the amount of weight waiting for the semaphore
@@ -222,5 +337,16 @@ func (rh *RequestHandler) checkStruct() error {
 	if rh.total == nil {
 		return errors.New("nil total counter, use the function NewRequestHandler to build the RequestHandler struct")
 	}
+	if rh.retries == nil {
+		return errors.New("nil retries counter, use the function NewRequestHandler to build the RequestHandler struct")
+	}
 	return nil
 }
+
+//Returns the number of attempts that were retried after a failed first attempt
+func (rh *RequestHandler) GetRetryCount() int {
+	if rh.retries == nil {
+		return 0
+	}
+	return rh.retries.Count()
+}
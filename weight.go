@@ -0,0 +1,43 @@
+package shclisem
+
+import (
+	"math"
+
+	"golang.org/x/sync/semaphore"
+)
+
+//getSem returns the semaphore currently in use, under a read lock, so a single
+//DoWeightedContext call acquires and releases against the same instance even if
+//SetTotalWeight swaps it out mid-flight.
+func (rh *RequestHandler) getSem() *semaphore.Weighted {
+	rh.semmux.RLock()
+	defer rh.semmux.RUnlock()
+	return rh.sem
+}
+
+/*
+SetTotalWeight swaps the underlying semaphore for a fresh one sized n, mirroring the
+pattern of adjusting max connections on a running server without dropping existing
+work. Calls to DoWeightedContext already past their Acquire keep releasing against the
+old semaphore; only new acquisitions see the new weight.
+*/
+func (rh *RequestHandler) SetTotalWeight(n int) error {
+	if n < 1 {
+		n = 1
+	} else if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	newSem := semaphore.NewWeighted(int64(n))
+	rh.semmux.Lock()
+	defer rh.semmux.Unlock()
+	rh.sem = newSem
+	rh.tweight = n
+	return nil
+}
+
+//Returns the total weight currently configured for the semaphore
+func (rh *RequestHandler) GetTotalWeight() int {
+	rh.semmux.RLock()
+	defer rh.semmux.RUnlock()
+	return rh.tweight
+}
@@ -0,0 +1,76 @@
+package shclisem
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//SetTotalWeight must swap in a fresh semaphore for new acquisitions without disturbing a
+//call that's already holding a unit of the old one.
+func TestSetTotalWeightSwapsMidFlightWithoutLeakingOldSemaphore(t *testing.T) {
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer fast.Close()
+
+	rh := NewRequestHandler(1, 5*time.Second, nil)
+
+	slowReq, _ := http.NewRequest(http.MethodGet, slow.URL, nil)
+	done := make(chan struct{})
+	go func() {
+		resp, err := rh.DoWeightedContext(slowReq, 1, context.Background())
+		if err == nil {
+			resp.Body.Close()
+		}
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the slow call acquire the original weight-1 semaphore
+
+	if err := rh.SetTotalWeight(2); err != nil {
+		t.Fatalf("SetTotalWeight: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	fastReq1, _ := http.NewRequest(http.MethodGet, fast.URL, nil)
+	resp1, err := rh.DoWeightedContext(fastReq1, 1, ctx)
+	if err != nil {
+		t.Fatalf("expected a fresh caller to acquire a unit of the new semaphore, got %v", err)
+	}
+	resp1.Body.Close()
+
+	fastReq2, _ := http.NewRequest(http.MethodGet, fast.URL, nil)
+	resp2, err := rh.DoWeightedContext(fastReq2, 1, ctx)
+	if err != nil {
+		t.Fatalf("expected a second fresh caller to acquire the new semaphore while the slow call still holds the old one, got %v", err)
+	}
+	resp2.Body.Close()
+
+	close(block)
+	<-done
+
+	if got := rh.GetTotalWeight(); got != 2 {
+		t.Fatalf("expected GetTotalWeight to stay 2 after the old in-flight call finished, got %d", got)
+	}
+}
+
+func TestSetTotalWeightClampsBelowOne(t *testing.T) {
+	rh := NewRequestHandler(1, time.Second, nil)
+	if err := rh.SetTotalWeight(0); err != nil {
+		t.Fatalf("SetTotalWeight: %v", err)
+	}
+	if got := rh.GetTotalWeight(); got != 1 {
+		t.Fatalf("expected weight to clamp to 1, got %d", got)
+	}
+}